@@ -0,0 +1,34 @@
+package golimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// getAddress resolves the client address for a request, stripping any
+// port. If header is a non-empty string and present on the request, it
+// takes precedence over r.RemoteAddr; when multiple comma separated
+// addresses are present, as with X-Forwarded-For, the last is used, since
+// that's the hop closest to this server and so the one a client can't
+// have spoofed by setting its own header. A configured header that's
+// missing or empty falls back to r.RemoteAddr rather than erroring.
+func getAddress(r *http.Request, header interface{}) (string, error) {
+	value := r.RemoteAddr
+
+	if h, ok := header.(string); ok && h != "" {
+		if v := r.Header.Get(h); v != "" {
+			value = v
+		}
+	}
+
+	parts := strings.Split(value, ",")
+	addr := strings.TrimSpace(parts[len(parts)-1])
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, nil
+	}
+
+	return host, nil
+}
@@ -0,0 +1,20 @@
+package golimit
+
+// Algorithm selects the strategy used to decide whether a request should
+// be limited.
+type Algorithm int
+
+const (
+	// FixedWindow counts requests in a fixed window, resetting the
+	// counter once the window's Duration elapses. This is the default,
+	// and allows bursts of up to 2x Requests at window boundaries.
+	FixedWindow Algorithm = iota
+	// SlidingWindow keeps a rolling window of request timestamps, giving
+	// an exact count of requests in the trailing Duration regardless of
+	// where they land relative to a fixed boundary.
+	SlidingWindow
+	// TokenBucket refills a bucket at a steady rate of Requests per
+	// Duration and allows short bursts up to BurstSize (which defaults
+	// to Requests when unset).
+	TokenBucket
+)
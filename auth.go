@@ -0,0 +1,49 @@
+package golimit
+
+import "net/http"
+
+// An AuthFunc inspects an incoming request, before any limits are
+// checked, and decides how it should be treated. Returning bypass == true
+// skips rate limiting entirely for this request: no Store lookups happen
+// and no X-RateLimit-* headers are set, which is useful for exempting
+// trusted internal callers. Otherwise the returned tier selects which
+// entry of Limiter.Tiers applies; an empty tier (or one missing from
+// Tiers) falls back to the base Limits passed to NewLimiter.
+type AuthFunc func(*http.Request) (tier string, bypass bool)
+
+// limitsFor returns the Limits that apply to method+path for tier,
+// falling back to the base Limits when tier is empty or unknown. Tier
+// Limits are scanned directly rather than going through the exact-match
+// LimitsMap, so they support the same method lists and glob/param Paths
+// as the base Limits, but via tierCache's precompiled patternLimits
+// rather than recompiling a regexp per request.
+func (l Limiter) limitsFor(tier, method, path string) []Limit {
+	if tier != "" {
+		if tierLimits, ok := l.Tiers[tier]; ok {
+			compiled := l.tierPatternLimits(tier, tierLimits)
+
+			var matched []Limit
+
+			for _, pl := range compiled {
+				if methodMatches(pl.limit, method) && pl.re.MatchString(path) {
+					matched = append(matched, pl.limit)
+				}
+			}
+
+			return matched
+		}
+	}
+
+	return l.limitsForRoute(method, path)
+}
+
+// tierPatternLimits returns tier's Limits precompiled into patternLimits,
+// via l.tierCache when one was set up by NewLimiter, falling back to
+// compiling them uncached for a Limiter built as a struct literal.
+func (l Limiter) tierPatternLimits(tier string, limits *Limits) []patternLimit {
+	if l.tierCache == nil {
+		return compilePatternLimits(limits)
+	}
+
+	return l.tierCache.patternLimits(tier, limits)
+}
@@ -0,0 +1,29 @@
+package golimit
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// An ErrorHandler writes the response for a request that has been rate
+// limited. Handle has already set the rate limit headers by the time
+// this is called; retryAfter is the number of seconds until the client
+// may retry.
+type ErrorHandler func(rw http.ResponseWriter, r *http.Request, limit Limit, retryAfter int64)
+
+type errorBody struct {
+	Error      string `json:"error"`
+	RetryAfter int64  `json:"retry_after"`
+}
+
+// defaultErrorHandler writes a 429 with a small JSON body holding a fixed
+// error message and retry_after, the seconds until the client may retry.
+func defaultErrorHandler(rw http.ResponseWriter, r *http.Request, limit Limit, retryAfter int64) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusTooManyRequests)
+
+	json.NewEncoder(rw).Encode(errorBody{
+		Error:      "429, Too Many Requests",
+		RetryAfter: retryAfter,
+	})
+}
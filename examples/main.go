@@ -15,7 +15,12 @@ func main() {
 	})
 
 	limits := &golimit.Limits{
-		golimit.Limit{"GET", "/", 3, 60},
+		golimit.Limit{
+			Method:   "GET",
+			Path:     "/",
+			Requests: 3,
+			Duration: 60,
+		},
 	}
 
 	limiter := golimit.NewLimiter(limits, nil, nil)
@@ -0,0 +1,59 @@
+package golimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HeaderStyle selects which family of rate-limit headers Handle sets on
+// every response to a route with a matching Limit, successful or not.
+// Requests bypassed by AuthFunc, and routes with no matching Limit, get
+// none.
+type HeaderStyle int
+
+const (
+	// HeaderLegacy sets the GitHub-style X-RateLimit-Remaining and
+	// X-RateLimit-Limit headers golimit has always used. This is the
+	// default.
+	HeaderLegacy HeaderStyle = iota
+	// HeaderIETF sets the draft-ietf-httpapi-ratelimit-headers headers:
+	// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+	// RateLimit-Policy.
+	HeaderIETF
+	// HeaderBoth sets both the legacy and IETF headers.
+	HeaderBoth
+)
+
+func setHeaders(rw http.ResponseWriter, style HeaderStyle, limit Limit, count int64, reset time.Duration, limited bool) {
+	remaining := limit.Requests - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	// Round up rather than truncate: a client that waits only the
+	// truncated number of seconds could still retry while over the
+	// limit, e.g. a 29.4s remaining PTTL must be reported as 30, not 29.
+	resetSeconds := int64(math.Ceil(reset.Seconds()))
+	if resetSeconds < 0 {
+		resetSeconds = 0
+	}
+
+	if style == HeaderLegacy || style == HeaderBoth {
+		rw.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		rw.Header().Set("X-RateLimit-Limit", strconv.FormatInt(limit.Requests, 10))
+
+		if limited {
+			rw.Header().Set("Retry-After", strconv.FormatInt(resetSeconds, 10))
+		}
+	}
+
+	if style == HeaderIETF || style == HeaderBoth {
+		rw.Header().Set("RateLimit-Limit", strconv.FormatInt(limit.Requests, 10))
+		rw.Header().Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		rw.Header().Set("RateLimit-Reset", strconv.FormatInt(resetSeconds, 10))
+		rw.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, limit.Duration))
+	}
+}
@@ -0,0 +1,46 @@
+package golimit
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// A KeyFunc computes the store key segment used to scope a Limit, for
+// example per IP, per API key, or a single shared bucket for a site-wide
+// limit.
+type KeyFunc func(*http.Request) (string, error)
+
+// KeyGlobal returns the same key for every request, so all callers share
+// a single counter. Useful for site-wide limits.
+func KeyGlobal(r *http.Request) (string, error) {
+	return "global", nil
+}
+
+// KeyByRemoteAddr keys by r.RemoteAddr, ignoring any proxy headers.
+func KeyByRemoteAddr(r *http.Request) (string, error) {
+	return getAddress(r, nil)
+}
+
+// KeyByIP returns a KeyFunc that keys by the client IP. Passing nil reads
+// r.RemoteAddr directly; passing a header name reads the IP from that
+// header instead, which is useful behind a proxy that sets e.g.
+// X-Forwarded-For.
+func KeyByIP(header interface{}) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return getAddress(r, header)
+	}
+}
+
+// KeyByHeader returns a KeyFunc that keys by the verbatim value of the
+// named header, for example an API key or an Authorization token. It
+// errors if the header isn't present on the request.
+func KeyByHeader(header string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(header)
+		if value == "" {
+			return "", fmt.Errorf("go-limit: header %q not present on request", header)
+		}
+
+		return value, nil
+	}
+}
@@ -1,103 +1,230 @@
-// Package golimit limits http requests storing requests in redis.
+// Package golimit limits http requests by storing request counts in a
+// pluggable Store.
 package golimit
 
 import (
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
-	"strconv"
-
-	"github.com/bradleyg/go-address"
-	"github.com/bradleyg/go-redisify"
-	"github.com/hoisie/redis"
+	"time"
 )
 
 // A Limit defines the necessary values to limit a request.
 type Limit struct {
 	// Method specifies what type of http method you want to rate limit.
+	// Use "*" to match every method, or set Methods instead to match a
+	// specific list.
 	Method string
-	// Path Specifies the path (r.URL.Path) of the which http requests to limit.
+	// Methods, when non-empty, overrides Method with a list of http
+	// methods this Limit applies to, e.g. Methods{"GET", "HEAD"}. "*"
+	// matches every method.
+	Methods Methods
+	// Path Specifies the path (r.URL.Path) of the which http requests to
+	// limit. Besides an exact path, Path may contain "*" to match a
+	// single path segment, "**" to match any number of segments, or
+	// gorilla/mux-style "{name}" parameters; all requests matching the
+	// pattern share the same rate limit counter, keyed on the pattern
+	// itself rather than the concrete path.
 	Path string
 	// Requests specifies how many requests are allowed before limiting begins.
+	// For Algorithm TokenBucket this is the refill rate, in requests per
+	// Duration, rather than a hard cap.
 	Requests int64
 	// Duration specifies the rate limit window in seconds.
 	Duration int64
+	// Algorithm selects how Requests/Duration are enforced. Defaults to
+	// FixedWindow.
+	Algorithm Algorithm
+	// BurstSize caps how many requests Algorithm TokenBucket allows in a
+	// single burst. Defaults to Requests when zero. Ignored by other
+	// algorithms.
+	BurstSize int64
+	// KeyFunc computes the store key used to scope this Limit, e.g. per
+	// IP, per API key, or one shared bucket for a site-wide limit. When
+	// nil, the Limiter's DefaultKeyFunc is used. See KeyByIP,
+	// KeyByHeader, KeyByRemoteAddr and KeyGlobal.
+	KeyFunc KeyFunc
 }
 
-// Limits holds a slice of Limit to allow multiple limited routes.
+// Limits holds a slice of Limit to allow multiple limited routes. More
+// than one Limit can share the same Method and Path, in which case all of
+// them apply to that route, e.g. a tight per-IP limit alongside a looser
+// site-wide one.
 type Limits []Limit
 
-// A Limiter is returned by New.
+// A Limiter is returned by NewLimiter.
 type Limiter struct {
 	// Header specifies the ip proxy header to look for to limit requests.
 	// For example Heroku uses X-FORWARDED-FOR. To look for the remote address
-	// rather than a proxy header use "nil".
+	// rather than a proxy header use "nil". Only used to build
+	// DefaultKeyFunc; once the Limiter is constructed changing it has no
+	// effect.
 	Header interface{}
-	// LimitsMap contains a map using method+path to speed up lookups.
+	// LimitsMap contains a map using method+path to speed up lookups for
+	// Limits with an exact Method and Path.
 	LimitsMap limitsMap
+	// patterns holds Limits that use a method list, "*", or a glob/param
+	// Path, scanned in declaration order when a route misses LimitsMap.
+	patterns []patternLimit
+	// Store persists request counts. See Store, MemoryStore and RedisStore.
+	Store Store
+	// DefaultKeyFunc is used for any Limit that doesn't set its own
+	// KeyFunc. Set by NewLimiter to KeyByIP(header), matching golimit's
+	// original per-IP behaviour; override it to change the default for
+	// every route at once.
+	DefaultKeyFunc KeyFunc
+	// AuthFunc, when set, is consulted before any limits are checked.
+	// See AuthFunc.
+	AuthFunc AuthFunc
+	// Tiers maps a tier name, as returned by AuthFunc, to the Limits
+	// that apply to it instead of the base Limits passed to NewLimiter.
+	Tiers map[string]*Limits
+	// tierCache compiles and caches each Tiers entry into patternLimits on
+	// first use, since Tiers is set directly rather than through
+	// NewLimiter. Set by NewLimiter; a Limiter built as a struct literal
+	// falls back to compiling uncached on every call if left nil.
+	tierCache *tierCache
+	// HeaderStyle selects which rate-limit headers are set on every
+	// response to a rate limited route. Defaults to HeaderLegacy.
+	HeaderStyle HeaderStyle
+	// ErrorHandler writes the response once a Limit has been exceeded.
+	// Defaults to defaultErrorHandler, a small JSON body. Set by
+	// NewLimiter; a Limiter built as a struct literal falls back to the
+	// default too if left nil.
+	ErrorHandler ErrorHandler
 }
 
-type limitsMap map[string]Limit
+type limitsMap map[string][]Limit
 
 var (
-	client  *redis.Client
 	logErr  = log.New(os.Stderr, "[go-limit:error] ", 0)
 	logInfo = log.New(os.Stdout, "[go-limit:info] ", 0)
 )
 
-func setHeaders(rw http.ResponseWriter, limit Limit, count int64, timeout int64) {
-	remaining := limit.Requests - count
-	if remaining < 0 {
-		remaining = 0
-	}
-
-	rw.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
-	rw.Header().Set("X-RateLimit-Limit", strconv.FormatInt(limit.Requests, 10))
-
-	if timeout >= 0 {
-		rw.Header().Set("Retry-After", strconv.FormatInt(timeout, 10))
-	}
-}
-
 // Creates a new rate limiter.
 //
 // By passing "nil" as the "header" argument you are asking to read the IP from r.RemoteAddr.
 //
-//  limiter := golimit.New(limits, nil, nil)
+//  limiter := golimit.NewLimiter(limits, nil, nil)
 //
 // You can also pass a string rather than nil to specify to look at a header rather than the remote
 // address. This is useful for when serving requests behind a proxy. For example
 // Heroku passes through the remote IP in the header "X-Forwarded-For".
 //
-//  limiter := golimit.New(limits, "X-Forwarded-For", nil)
+//  limiter := golimit.NewLimiter(limits, "X-Forwarded-For", nil)
+//
+// header is used to build DefaultKeyFunc via KeyByIP; set individual
+// Limit.KeyFunc or Limiter.DefaultKeyFunc afterwards for other keying
+// strategies.
 //
-// If you already have a redis connection available via github.com/hoisie/redis
-// you can pass it as the last parameter. Passing nil will create a new redis
-// connection. The default connection will user localhost but the enviroment
-// variable "REDIS_URL" can also be set and used.
+// golimit stores request counts in a Store. Passing nil uses a RedisStore
+// connecting via the enviroment variable "REDIS_URL" (defaulting to
+// localhost). Pass your own Store, such as a MemoryStore or a RedisStore
+// wrapping an existing github.com/hoisie/redis connection, to control this.
 //
-//  limiter := golimit.New(limits, "X-Forwarded-For", &client)
+//  limiter := golimit.NewLimiter(limits, "X-Forwarded-For", golimit.NewMemoryStore())
 //
-func New(limits *Limits, header interface{}, c *redis.Client) *Limiter {
+func NewLimiter(limits *Limits, header interface{}, store Store) *Limiter {
 	lMap := make(limitsMap)
+	var patterns []patternLimit
 
-	if c == nil {
-		var err error
-		client, err = goredisify.Conn(os.Getenv("REDIS_URL"))
+	if store == nil {
+		s, err := NewRedisStore(nil)
 		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		client = c
+		store = s
 	}
 
 	for _, limit := range *limits {
+		if needsPatternMatch(limit) {
+			patterns = append(patterns, patternLimit{limit: limit, re: compilePath(limit.Path)})
+			continue
+		}
+
 		key := limit.Method + ":" + limit.Path
-		lMap[key] = limit
+		lMap[key] = append(lMap[key], limit)
+	}
+
+	return &Limiter{
+		Header:         header,
+		LimitsMap:      lMap,
+		patterns:       patterns,
+		Store:          store,
+		DefaultKeyFunc: KeyByIP(header),
+		tierCache:      &tierCache{compiled: make(map[string][]patternLimit)},
+		ErrorHandler:   defaultErrorHandler,
+	}
+}
+
+// limitsForRoute returns the Limits that apply to method+path, preferring
+// the O(1) exact match and falling through to a scan of pattern Limits,
+// in declaration order, when it misses.
+func (l Limiter) limitsForRoute(method, path string) []Limit {
+	if limits, ok := l.LimitsMap[method+":"+path]; ok && len(limits) > 0 {
+		return limits
+	}
+
+	var matched []Limit
+
+	for _, pl := range l.patterns {
+		if methodMatches(pl.limit, method) && pl.re.MatchString(path) {
+			matched = append(matched, pl.limit)
+		}
 	}
 
-	return &Limiter{header, lMap}
+	return matched
+}
+
+// take evaluates a single Limit against its store key, returning the
+// (possibly adjusted, see Algorithm TokenBucket) Limit, the current
+// count, the ttl until the window/bucket resets, and whether this request
+// should be limited.
+func (l Limiter) take(limit Limit, key string, duration time.Duration) (Limit, int64, time.Duration, bool, error) {
+	switch limit.Algorithm {
+	case SlidingWindow:
+		ws, ok := l.Store.(windowedStore)
+		if !ok {
+			return limit, 0, 0, false, fmt.Errorf("go-limit: store does not support Algorithm SlidingWindow")
+		}
+
+		count, err := ws.TakeSlidingWindow(key, duration)
+		if err != nil {
+			return limit, 0, 0, false, err
+		}
+
+		return limit, count, duration, count > limit.Requests, nil
+	case TokenBucket:
+		ws, ok := l.Store.(windowedStore)
+		if !ok {
+			return limit, 0, 0, false, fmt.Errorf("go-limit: store does not support Algorithm TokenBucket")
+		}
+
+		capacity := limit.BurstSize
+		if capacity <= 0 {
+			capacity = limit.Requests
+		}
+
+		rate := float64(limit.Requests) / float64(limit.Duration)
+
+		allowed, remaining, retryAfter, err := ws.TakeTokenBucket(key, rate, capacity, duration)
+		if err != nil {
+			return limit, 0, 0, false, err
+		}
+
+		limit.Requests = capacity
+
+		return limit, capacity - remaining, retryAfter, !allowed, nil
+	default:
+		count, ttl, err := l.Store.Incr(key, duration)
+		if err != nil {
+			return limit, 0, 0, false, err
+		}
+
+		return limit, count, ttl, count > limit.Requests, nil
+	}
 }
 
 // Handler takes and returns a http.Handler. Best used as a middleware chain.
@@ -105,61 +232,91 @@ func New(limits *Limits, header interface{}, c *redis.Client) *Limiter {
 //   mux := http.NewServeMux()
 //   mux.HandleFunc("/", ...)
 //
-//   limiter := golimit.New(...)
+//   limiter := golimit.NewLimiter(...)
 //   http.ListenAndServe(":80", limiter.Handle(mux))
 //
+// When more than one Limit is registered for a route, all of them are
+// checked and incremented; the response is rejected if any is exceeded,
+// using the longest retry-after among the ones that were.
 func (l Limiter) Handle(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		lMap := l.LimitsMap
+		limits := l.limitsForRoute(r.Method, r.URL.Path)
 
-		limit, ok := lMap[r.Method+":"+r.URL.Path]
-		if !ok {
-			handler.ServeHTTP(rw, r)
-			return
+		if l.AuthFunc != nil {
+			tier, bypass := l.AuthFunc(r)
+			if bypass {
+				handler.ServeHTTP(rw, r)
+				return
+			}
+
+			limits = l.limitsFor(tier, r.Method, r.URL.Path)
 		}
 
-		address, err := goaddress.Get(r, l.Header)
-		if err != nil {
-			logErr.Println(err)
-			rw.WriteHeader(http.StatusBadRequest)
+		if len(limits) == 0 {
+			handler.ServeHTTP(rw, r)
 			return
 		}
 
-		key := "go-ratelimit:(" + address + ")" + r.Method + r.URL.Path
+		var (
+			limited     bool
+			retryAfter  time.Duration
+			haveReport  bool
+			reportLimit Limit
+			reportCount int64
+			reportReset time.Duration
+		)
 
-		count, err := client.Incr(key)
-		if err != nil {
-			logErr.Println(err)
-			rw.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		for _, limit := range limits {
+			keyFunc := limit.KeyFunc
+			if keyFunc == nil {
+				keyFunc = l.DefaultKeyFunc
+			}
 
-		if count == 1 {
-			_, err := client.Expire(key, limit.Duration)
+			address, err := keyFunc(r)
 			if err != nil {
 				logErr.Println(err)
-				rw.WriteHeader(http.StatusInternalServerError)
+				rw.WriteHeader(http.StatusBadRequest)
 				return
 			}
-		}
 
-		if count > limit.Requests {
-			timeout, err := client.Ttl(key)
+			key := fmt.Sprintf("go-ratelimit:(%s)%s%s:%d:%d:%d", address, methodKey(limit), limit.Path, limit.Duration, limit.Requests, limit.Algorithm)
+			duration := time.Duration(limit.Duration) * time.Second
+
+			resolved, count, ttl, exceeded, err := l.take(limit, key, duration)
 			if err != nil {
 				logErr.Println(err)
 				rw.WriteHeader(http.StatusInternalServerError)
 				return
 			}
 
-			logInfo.Println("Limiting " + key)
+			if !haveReport || resolved.Requests-count < reportLimit.Requests-reportCount {
+				haveReport = true
+				reportLimit = resolved
+				reportCount = count
+				reportReset = ttl
+			}
+
+			if exceeded && (!limited || ttl > retryAfter) {
+				limited = true
+				retryAfter = ttl
+			}
+		}
+
+		if limited {
+			logInfo.Println("Limiting " + r.Method + r.URL.Path)
+
+			setHeaders(rw, l.HeaderStyle, reportLimit, reportCount, retryAfter, true)
+
+			errHandler := l.ErrorHandler
+			if errHandler == nil {
+				errHandler = defaultErrorHandler
+			}
 
-			setHeaders(rw, limit, count, timeout)
-			rw.WriteHeader(429)
-			fmt.Fprintf(rw, "429, Too Many Requests")
+			errHandler(rw, r, reportLimit, int64(math.Ceil(retryAfter.Seconds())))
 			return
 		}
 
-		setHeaders(rw, limit, count, -1)
+		setHeaders(rw, l.HeaderStyle, reportLimit, reportCount, reportReset, false)
 		handler.ServeHTTP(rw, r)
 	})
 }
@@ -1,6 +1,7 @@
 package golimit
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,10 +13,22 @@ import (
 )
 
 var (
-	limiter *Limiter
-	req     *http.Request
+	limiter       *Limiter
+	tieredLimiter *Limiter
+	ietfLimiter   *Limiter
+	req           *http.Request
+	client        *RedisStore
 )
 
+func redisConn() *RedisStore {
+	store, err := NewRedisStore(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return store
+}
+
 func init() {
 	client = redisConn()
 
@@ -41,10 +54,101 @@ func init() {
 			Requests: 5,
 			Duration: 1,
 		},
+		Limit{
+			Method:    "GET",
+			Path:      "/sliding",
+			Requests:  5,
+			Duration:  1,
+			Algorithm: SlidingWindow,
+		},
+		Limit{
+			Method:    "GET",
+			Path:      "/bucket",
+			Requests:  5,
+			Duration:  1,
+			Algorithm: TokenBucket,
+			BurstSize: 5,
+		},
+		Limit{
+			Method:    "GET",
+			Path:      "/bucket-slow",
+			Requests:  5,
+			Duration:  3600,
+			Algorithm: TokenBucket,
+			BurstSize: 1,
+		},
+		Limit{
+			Method:   "GET",
+			Path:     "/multi",
+			Requests: 5,
+			Duration: 30,
+		},
+		Limit{
+			Method:   "GET",
+			Path:     "/multi",
+			Requests: 1,
+			Duration: 30,
+			KeyFunc:  KeyGlobal,
+		},
+		Limit{
+			Method:   "GET",
+			Path:     "/users/{id}",
+			Requests: 3,
+			Duration: 30,
+		},
+		Limit{
+			Methods:  Methods{"GET", "HEAD"},
+			Path:     "/shared",
+			Requests: 3,
+			Duration: 30,
+		},
 	}
 
 	limiter = NewLimiter(limits, "", nil)
 
+	tieredLimits := &Limits{
+		Limit{
+			Method:   "GET",
+			Path:     "/tiered",
+			Requests: 1,
+			Duration: 30,
+		},
+	}
+
+	tieredLimiter = NewLimiter(tieredLimits, "", nil)
+	tieredLimiter.Tiers = map[string]*Limits{
+		"gold": &Limits{
+			Limit{
+				Method:   "GET",
+				Path:     "/tiered",
+				Requests: 5,
+				Duration: 30,
+			},
+		},
+	}
+	tieredLimiter.AuthFunc = func(r *http.Request) (string, bool) {
+		switch r.Header.Get("X-API-Key") {
+		case "internal":
+			return "", true
+		case "gold-key":
+			return "gold", false
+		default:
+			return "", false
+		}
+	}
+
+	ietfLimits := &Limits{
+		Limit{
+			Method:   "GET",
+			Path:     "/ietf",
+			Requests: 5,
+			Duration: 30,
+		},
+	}
+
+	ietfLimiter = NewLimiter(ietfLimits, "", nil)
+	ietfLimiter.HeaderStyle = HeaderIETF
+
 	r, err := http.NewRequest("GET", "/test", nil)
 	if err != nil {
 		log.Fatal(err)
@@ -66,8 +170,8 @@ func TestRateLimit(t *testing.T) {
 	srv := httptest.NewServer(h)
 
 	var i int64
-	limit := limiter.LimitsMap["GET:/test"].Requests
-	duration := limiter.LimitsMap["GET:/test"].Duration
+	limit := limiter.LimitsMap["GET:/test"][0].Requests
+	duration := limiter.LimitsMap["GET:/test"][0].Duration
 
 	for i = 0; i <= limit; i++ {
 
@@ -102,8 +206,13 @@ func TestRateLimit(t *testing.T) {
 				t.Fatalf("Incorrect status code when limiting. Expected %d, Actual %d", 429, res.StatusCode)
 			}
 
-			if string(body) != "" {
-				t.Fatal("Body should be empty when rate limiting. Expected %s, Actual %s", "", string(body))
+			var errBody errorBody
+			if err := json.Unmarshal(body, &errBody); err != nil {
+				t.Fatalf("Could not decode error body as JSON: %s", err)
+			}
+
+			if errBody.RetryAfter != duration {
+				t.Fatalf("Error body retry_after incorrect. Expected %d, Actual %d", duration, errBody.RetryAfter)
 			}
 
 			retryAfter, err := strconv.ParseInt(res.Header.Get("Retry-After"), 10, 64)
@@ -135,7 +244,7 @@ func TestRateLimitExpire(t *testing.T) {
 	srv := httptest.NewServer(h)
 
 	var i int64
-	limit := limiter.LimitsMap["GET:/expire"].Requests
+	limit := limiter.LimitsMap["GET:/expire"][0].Requests
 
 	for i = 0; i <= limit+1; i++ {
 		res, err := http.Get(srv.URL + "/expire")
@@ -158,6 +267,288 @@ func TestRateLimitExpire(t *testing.T) {
 	}
 }
 
+func TestRateLimitSlidingWindow(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sliding", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	var i int64
+	limit := limiter.LimitsMap["GET:/sliding"][0].Requests
+
+	for i = 0; i <= limit; i++ {
+		res, err := http.Get(srv.URL + "/sliding")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if i == limit && res.StatusCode != 429 {
+			t.Fatalf("Incorrect status code when limiting. Expected %d, Actual %d", 429, res.StatusCode)
+		}
+
+		if i != limit && res.StatusCode != http.StatusOK {
+			t.Fatalf("Incorrect status code returned when not limiting. Expected %d, Actual %d", http.StatusOK, res.StatusCode)
+		}
+	}
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bucket", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	var i int64
+	limit := limiter.LimitsMap["GET:/bucket"][0].BurstSize
+
+	for i = 0; i <= limit; i++ {
+		res, err := http.Get(srv.URL + "/bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if i == limit && res.StatusCode != 429 {
+			t.Fatalf("Incorrect status code when limiting. Expected %d, Actual %d", 429, res.StatusCode)
+		}
+
+		if i != limit && res.StatusCode != http.StatusOK {
+			t.Fatalf("Incorrect status code returned when not limiting. Expected %d, Actual %d", http.StatusOK, res.StatusCode)
+		}
+	}
+}
+
+// TestRateLimitTokenBucketHonoursDuration uses a Duration much longer than
+// 1 second to catch a refill rate computed as Requests tokens/sec instead
+// of Requests/Duration tokens/sec (the latter being the documented
+// behaviour). With Requests: 5, Duration: 3600, the bucket should refill
+// far too slowly to grant a second token moments after the first is spent.
+func TestRateLimitTokenBucketHonoursDuration(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bucket-slow", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	res, err := http.Get(srv.URL + "/bucket-slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Incorrect status code for first request. Expected %d, Actual %d", http.StatusOK, res.StatusCode)
+	}
+
+	res, err = http.Get(srv.URL + "/bucket-slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 429 {
+		t.Fatalf("Incorrect status code for second request. Expected %d, Actual %d", 429, res.StatusCode)
+	}
+
+	retryAfter, err := strconv.ParseInt(res.Header.Get("Retry-After"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// At the correct rate of 5/3600 tokens/sec, refilling the spent token
+	// takes ~720s; the bug computed 5 tokens/sec, which would report a
+	// Retry-After of 1s or less.
+	if retryAfter < 600 {
+		t.Fatalf("Retry-After too low, refill rate did not honour Duration. Expected >= %d, Actual %d", 600, retryAfter)
+	}
+}
+
+func TestRateLimitMultipleLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/multi", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	// The second Limit on /multi is a KeyGlobal limit of 1/30s, so the
+	// very first request already consumes its only slot; the second
+	// request should be rejected even though the per-IP limit of 5/30s
+	// has plenty of headroom left.
+	res, err := http.Get(srv.URL + "/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Incorrect status code on first request. Expected %d, Actual %d", http.StatusOK, res.StatusCode)
+	}
+
+	res, err = http.Get(srv.URL + "/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 429 {
+		t.Fatalf("Incorrect status code once the global limit is exceeded. Expected %d, Actual %d", 429, res.StatusCode)
+	}
+}
+
+func TestRateLimitTierBypass(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tiered", testHandler)
+
+	h := tieredLimiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	for i := 0; i < 3; i++ {
+		r, err := http.NewRequest("GET", srv.URL+"/tiered", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-API-Key", "internal")
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Bypassed request should never be limited. Expected %d, Actual %d", http.StatusOK, res.StatusCode)
+		}
+
+		if res.Header.Get("X-RateLimit-Limit") != "" {
+			t.Fatal("Bypassed request should not carry rate limit headers")
+		}
+	}
+}
+
+func TestRateLimitTierOverridesBaseLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tiered", testHandler)
+
+	h := tieredLimiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	// The base limit on /tiered is 1/30s, but the "gold" tier allows 5,
+	// so a gold-keyed client should get past the first request, which
+	// an untiered client would already be limited on.
+	for i := 0; i < 5; i++ {
+		r, err := http.NewRequest("GET", srv.URL+"/tiered", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-API-Key", "gold-key")
+
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Request %d within gold tier should not be limited. Actual %d", i, res.StatusCode)
+		}
+	}
+}
+
+func TestRateLimitPatternPathSharesCounter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/1", testHandler)
+	mux.HandleFunc("/users/2", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	// The limit is registered against the pattern "/users/{id}", so hits
+	// to two different concrete ids should share the same counter.
+	paths := []string{"/users/1", "/users/2", "/users/1", "/users/2"}
+
+	var last *http.Response
+	for _, p := range paths {
+		res, err := http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		last = res
+	}
+
+	if last.StatusCode != 429 {
+		t.Fatalf("Expected the 4th request across /users/{id} to be limited. Actual %d", last.StatusCode)
+	}
+}
+
+func TestRateLimitMethodList(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shared", testHandler)
+
+	h := limiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	// Requests is 3, and the Limit applies to both GET and HEAD, so the
+	// 4th request across either method should be limited.
+	methods := []string{"GET", "HEAD", "GET", "HEAD"}
+
+	var last *http.Response
+	for _, m := range methods {
+		req, err := http.NewRequest(m, srv.URL+"/shared", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		last = res
+	}
+
+	if last.StatusCode != 429 {
+		t.Fatalf("Expected the 4th request across GET+HEAD /shared to be limited. Actual %d", last.StatusCode)
+	}
+}
+
+func TestRateLimitIETFHeaders(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ietf", testHandler)
+
+	h := ietfLimiter.Handle(mux)
+	srv := httptest.NewServer(h)
+
+	res, err := http.Get(srv.URL + "/ietf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.Header.Get("X-RateLimit-Limit") != "" {
+		t.Fatal("Legacy headers should not be set under HeaderIETF")
+	}
+
+	if res.Header.Get("RateLimit-Limit") != "5" {
+		t.Fatalf("RateLimit-Limit incorrect. Expected %s, Actual %s", "5", res.Header.Get("RateLimit-Limit"))
+	}
+
+	if res.Header.Get("RateLimit-Remaining") != "4" {
+		t.Fatalf("RateLimit-Remaining incorrect. Expected %s, Actual %s", "4", res.Header.Get("RateLimit-Remaining"))
+	}
+
+	if res.Header.Get("RateLimit-Policy") != "5;w=30" {
+		t.Fatalf("RateLimit-Policy incorrect. Expected %s, Actual %s", "5;w=30", res.Header.Get("RateLimit-Policy"))
+	}
+
+	if res.Header.Get("RateLimit-Reset") == "" {
+		t.Fatal("RateLimit-Reset should be set on a successful response")
+	}
+}
+
 func TestGetAddressWithPort(t *testing.T) {
 	req.RemoteAddr = "0.0.0.0:80"
 
@@ -192,14 +583,22 @@ func TestGetAddressWithHeader(t *testing.T) {
 		t.Fatalf("%s", err.Error())
 	}
 
-	if address != "1.1.1.1" {
-		t.Fatalf("Address doesn't match. Expected %s, Actual %s", "1.1.1.1", address)
+	// The last entry is the hop closest to this server, and so the one a
+	// client can't have spoofed by setting its own header.
+	if address != "2.2.2.2" {
+		t.Fatalf("Address doesn't match. Expected %s, Actual %s", "2.2.2.2", address)
 	}
 }
 
 func TestGetAddressWithNoAddress(t *testing.T) {
-	_, err := getAddress(req, "MISSING_ADDRESS")
-	if err == nil {
-		t.Fatalf("A missing address should return an error")
+	req.RemoteAddr = "0.0.0.0"
+
+	address, err := getAddress(req, "MISSING_ADDRESS")
+	if err != nil {
+		t.Fatalf("%s", err.Error())
+	}
+
+	if address != "0.0.0.0" {
+		t.Fatalf("A missing header should fall back to RemoteAddr. Expected %s, Actual %s", "0.0.0.0", address)
 	}
 }
@@ -0,0 +1,66 @@
+package golimit
+
+import (
+	"sync"
+	"time"
+)
+
+// A MemoryStore is a Store backed by an in-process map. It has no external
+// dependencies, which makes it a good fit for single-node deployments and
+// for tests that shouldn't need a running redis. Expired keys are reaped
+// lazily, on the next Incr or Peek that touches them.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+type memoryEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore ready for use.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Incr(key string, duration time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expiresAt) {
+		e = &memoryEntry{expiresAt: time.Now().Add(duration)}
+		s.entries[key] = e
+	}
+
+	e.count++
+
+	return e.count, time.Until(e.expiresAt), nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+
+	return nil
+}
+
+func (s *MemoryStore) Peek(key string) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return 0, 0, nil
+	}
+
+	if time.Now().After(e.expiresAt) {
+		delete(s.entries, key)
+		return 0, 0, nil
+	}
+
+	return e.count, time.Until(e.expiresAt), nil
+}
@@ -0,0 +1,102 @@
+package golimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreIncr(t *testing.T) {
+	store := NewMemoryStore()
+
+	count, ttl, err := store.Incr("a", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("count incorrect. Expected %d, Actual %d", 1, count)
+	}
+
+	if ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("ttl out of range. Actual %s", ttl)
+	}
+
+	count, _, err = store.Incr("a", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 2 {
+		t.Fatalf("count incorrect. Expected %d, Actual %d", 2, count)
+	}
+}
+
+func TestMemoryStoreIncrExpires(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, _, err := store.Incr("b", 10*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	count, _, err := store.Incr("b", time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("count should have reset after expiry. Expected %d, Actual %d", 1, count)
+	}
+}
+
+func TestMemoryStoreReset(t *testing.T) {
+	store := NewMemoryStore()
+
+	if _, _, err := store.Incr("c", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := store.Reset("c"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	count, ttl, err := store.Peek("c")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 0 || ttl != 0 {
+		t.Fatalf("expected no entry after reset. Actual count %d, ttl %s", count, ttl)
+	}
+}
+
+func TestMemoryStorePeek(t *testing.T) {
+	store := NewMemoryStore()
+
+	count, ttl, err := store.Peek("d")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 0 || ttl != 0 {
+		t.Fatalf("expected no entry for unseen key. Actual count %d, ttl %s", count, ttl)
+	}
+
+	if _, _, err := store.Incr("d", time.Minute); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	count, ttl, err = store.Peek("d")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("count incorrect. Expected %d, Actual %d", 1, count)
+	}
+
+	if ttl <= 0 {
+		t.Fatalf("expected positive ttl, actual %s", ttl)
+	}
+}
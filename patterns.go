@@ -0,0 +1,133 @@
+package golimit
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Methods lists the http methods a Limit applies to. A nil or empty
+// Methods falls back to the single Limit.Method; either field can use
+// "*" to match every method.
+type Methods []string
+
+// A patternLimit is a Limit whose Path couldn't be matched via the exact
+// method+path map, because it uses a glob segment, a "{name}" parameter,
+// a method list, or "*" for its method.
+type patternLimit struct {
+	limit Limit
+	re    *regexp.Regexp
+}
+
+// isPattern reports whether path needs compiling into a matcher rather
+// than being usable as a literal key in limitsMap.
+func isPattern(path string) bool {
+	return strings.ContainsAny(path, "*{")
+}
+
+// compilePath turns a path containing "*"/"**" globs or gorilla/mux-style
+// "{name}" parameters into a regexp that matches concrete request paths.
+// A "*" matches a single path segment, "**" matches any number of them,
+// and "{name}" matches a single non-empty segment.
+func compilePath(path string) *regexp.Regexp {
+	segments := strings.Split(path, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i, seg := range segments {
+		if i > 0 {
+			b.WriteString("/")
+		}
+
+		switch {
+		case seg == "**":
+			b.WriteString(".*")
+		case seg == "*":
+			b.WriteString("[^/]*")
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			b.WriteString("[^/]+")
+		default:
+			b.WriteString(regexp.QuoteMeta(seg))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+// methodMatches reports whether limit applies to method, honouring
+// Methods, "*" and the plain single-method case.
+func methodMatches(limit Limit, method string) bool {
+	if len(limit.Methods) > 0 {
+		for _, m := range limit.Methods {
+			if m == "*" || m == method {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return limit.Method == "*" || limit.Method == method
+}
+
+// needsPatternMatch reports whether limit can't live in the exact
+// method+path map and must go through the slower pattern scan instead.
+func needsPatternMatch(limit Limit) bool {
+	return len(limit.Methods) > 0 || limit.Method == "*" || isPattern(limit.Path)
+}
+
+// methodKey returns a token identifying which method(s) limit applies to,
+// for use in its store key. It's derived from the Limit itself rather
+// than the request's method, so every request a Methods list or a "*"
+// Method matches shares one counter instead of splitting into one per
+// method actually seen.
+func methodKey(limit Limit) string {
+	if len(limit.Methods) > 0 {
+		return strings.Join(limit.Methods, ",")
+	}
+
+	return limit.Method
+}
+
+// compilePatternLimits compiles every Limit in limits into a patternLimit,
+// the same way NewLimiter compiles the base Limits, so a tier's Limits can
+// be scanned against precompiled regexps instead of recompiling one per
+// request.
+func compilePatternLimits(limits *Limits) []patternLimit {
+	compiled := make([]patternLimit, 0, len(*limits))
+
+	for _, limit := range *limits {
+		compiled = append(compiled, patternLimit{limit: limit, re: compilePath(limit.Path)})
+	}
+
+	return compiled
+}
+
+// tierCache holds precompiled patternLimits per tier name. Tiers is set
+// directly on a Limiter after construction (see AuthFunc), so it can't be
+// precompiled in NewLimiter the way the base Limits are; tierCache lets
+// limitsFor compile each tier's Limits once, on first use, instead of
+// recompiling them on every request.
+type tierCache struct {
+	mu       sync.Mutex
+	compiled map[string][]patternLimit
+}
+
+// patternLimits returns tier's Limits precompiled into patternLimits,
+// compiling and caching them on first use.
+func (c *tierCache) patternLimits(tier string, limits *Limits) []patternLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if compiled, ok := c.compiled[tier]; ok {
+		return compiled
+	}
+
+	compiled := compilePatternLimits(limits)
+	c.compiled[tier] = compiled
+
+	return compiled
+}
@@ -0,0 +1,235 @@
+package golimit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hoisie/redis"
+)
+
+// evalPoolSize caps how many concurrent RESP connections evalConn keeps
+// open, matching the pool of connections redis.Client keeps for its own
+// commands, so EVAL/EVALSHA traffic gets the same amount of concurrency.
+const evalPoolSize = 5
+
+// An evalLink is one persistent connection in evalConn's pool.
+type evalLink struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// github.com/hoisie/redis has no EVAL/EVALSHA/SCRIPT support, and doesn't
+// expose its connection pool for us to borrow, so evalConn speaks just
+// enough of the RESP protocol itself to run incrScript and
+// tokenBucketScript, over its own small pool of persistent connections
+// dialed straight at the wrapped client's own Addr/Password/Db (mirroring
+// how redis.Client.openConnection connects and authenticates). links is a
+// buffered channel of evalPoolSize slots, each either a live *evalLink or
+// nil for a slot that still needs dialing; borrowing blocks when every
+// slot is checked out, and a failed command drops its link so the slot
+// redials on its next use.
+type evalConn struct {
+	client *redis.Client
+
+	initOnce sync.Once
+	links    chan *evalLink
+}
+
+func (e *evalConn) init() {
+	e.links = make(chan *evalLink, evalPoolSize)
+	for i := 0; i < evalPoolSize; i++ {
+		e.links <- nil
+	}
+}
+
+func (e *evalConn) addr() string {
+	if e.client.Addr != "" {
+		return e.client.Addr
+	}
+
+	return "127.0.0.1:6379"
+}
+
+// dial opens a fresh connection and authenticates/selects a db if
+// configured.
+func (e *evalConn) dial() (*evalLink, error) {
+	conn, err := net.Dial("tcp", e.addr())
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	if e.client.Password != "" {
+		if _, err := sendCommand(conn, r, "AUTH", e.client.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if e.client.Db != 0 {
+		if _, err := sendCommand(conn, r, "SELECT", strconv.Itoa(e.client.Db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return &evalLink{conn: conn, r: r}, nil
+}
+
+// do borrows a link from the pool, dialing it first if the slot hasn't
+// been used yet or its previous link failed, runs args as a single
+// command, and returns its parsed reply.
+func (e *evalConn) do(args ...string) (interface{}, error) {
+	e.initOnce.Do(e.init)
+
+	link := <-e.links
+	if link == nil {
+		var err error
+
+		link, err = e.dial()
+		if err != nil {
+			e.links <- nil
+			return nil, err
+		}
+	}
+
+	reply, err := sendCommand(link.conn, link.r, args...)
+	if err != nil {
+		link.conn.Close()
+		e.links <- nil
+
+		return nil, err
+	}
+
+	e.links <- link
+
+	return reply, nil
+}
+
+func (e *evalConn) scriptLoad(script string) (string, error) {
+	reply, err := e.do("SCRIPT", "LOAD", script)
+	if err != nil {
+		return "", err
+	}
+
+	sha, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("go-limit: unexpected SCRIPT LOAD reply %v", reply)
+	}
+
+	return sha, nil
+}
+
+func (e *evalConn) evalSha(sha string, keys, argv []string) (interface{}, error) {
+	return e.do(scriptArgs("EVALSHA", sha, keys, argv)...)
+}
+
+func (e *evalConn) eval(script string, keys, argv []string) (interface{}, error) {
+	return e.do(scriptArgs("EVAL", script, keys, argv)...)
+}
+
+// scriptArgs builds the argument list for an EVAL/EVALSHA command: the
+// script or SHA, the key count, the keys, and then the ARGV values.
+func scriptArgs(cmd, scriptOrSHA string, keys, argv []string) []string {
+	args := make([]string, 0, 3+len(keys)+len(argv))
+	args = append(args, cmd, scriptOrSHA, strconv.Itoa(len(keys)))
+	args = append(args, keys...)
+	args = append(args, argv...)
+
+	return args
+}
+
+func sendCommand(conn net.Conn, r *bufio.Reader, args ...string) (interface{}, error) {
+	if _, err := conn.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+
+	return readReply(r)
+}
+
+func encodeCommand(args []string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	return buf.Bytes()
+}
+
+// readReply parses a single RESP reply: a simple string, an error, an
+// integer, a bulk string, or an array of any of those one level deep,
+// which is all incrScript and tokenBucketScript ever return.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("go-limit: empty redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("go-limit: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		return readBulkString(r, line[1:])
+	case '*':
+		return readArray(r, line[1:])
+	default:
+		return nil, fmt.Errorf("go-limit: unexpected redis reply %q", line)
+	}
+}
+
+func readBulkString(r *bufio.Reader, sizeStr string) (interface{}, error) {
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return string(buf[:size]), nil
+}
+
+func readArray(r *bufio.Reader, sizeStr string) (interface{}, error) {
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 {
+		return nil, nil
+	}
+
+	items := make([]interface{}, size)
+	for i := range items {
+		items[i], err = readReply(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
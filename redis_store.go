@@ -0,0 +1,269 @@
+package golimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyg/go-redisify"
+	"github.com/hoisie/redis"
+)
+
+// incrScript increments KEYS[1] and, only on the first hit, sets its
+// expiry in the same round trip, closing the race where a process killed
+// between a separate INCR and EXPIRE would leave the key to live forever.
+// It also returns the remaining PTTL so callers don't need a second
+// round trip to read it back. Run via evalConn, since github.com/hoisie/redis
+// has no EVAL/EVALSHA/SCRIPT support of its own.
+const incrScript = `
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`
+
+// tokenBucketScript refills and takes from a token bucket stored in a
+// redis hash under KEYS[1], atomically, so concurrent requests can't read
+// stale tokens between the refill calculation and the deduction.
+const tokenBucketScript = `
+local data = redis.call("HMGET", KEYS[1], "tokens", "last_refill_ns")
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local tokens = tonumber(data[1])
+local lastRefill = tonumber(data[2])
+if tokens == nil then
+	tokens = capacity
+	lastRefill = now
+end
+
+tokens = math.min(capacity, tokens + (now - lastRefill) * (rate / 1e9))
+
+local allowed = 0
+local retryAfter = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retryAfter = (1 - tokens) / (rate / 1e9) / 1e9
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tokens, "last_refill_ns", now)
+redis.call("EXPIRE", KEYS[1], ttl)
+
+return {allowed, tostring(retryAfter), tostring(math.floor(tokens))}
+`
+
+// A RedisStore is a Store backed by redis, shared across processes.
+type RedisStore struct {
+	client *redis.Client
+	eval   *evalConn
+
+	shaMu   sync.Mutex
+	incrSHA string
+}
+
+// NewRedisStore wraps an existing redis client as a Store. Passing nil
+// connects using the "REDIS_URL" environment variable, falling back to
+// localhost, matching the connection behaviour golimit has always had.
+func NewRedisStore(c *redis.Client) (*RedisStore, error) {
+	conn := c
+
+	if conn == nil {
+		var err error
+
+		conn, err = goredisify.Conn(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store := &RedisStore{client: conn, eval: &evalConn{client: conn}}
+
+	// Caching the SHA up front means the common case is a single
+	// EVALSHA round trip; if the script was since flushed from the
+	// server, Incr falls back to EVAL and reloads it below.
+	if sha, err := store.eval.scriptLoad(incrScript); err == nil {
+		store.storeIncrSHA(sha)
+	}
+
+	return store, nil
+}
+
+func (s *RedisStore) Incr(key string, duration time.Duration) (int64, time.Duration, error) {
+	ttlMs := int64(duration / time.Millisecond)
+
+	reply, err := s.evalIncr(key, ttlMs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 2 {
+		return 0, 0, fmt.Errorf("go-limit: unexpected incr reply %v", reply)
+	}
+
+	count, err := strconv.ParseInt(fmt.Sprint(parts[0]), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pttl, err := strconv.ParseInt(fmt.Sprint(parts[1]), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, time.Duration(pttl) * time.Millisecond, nil
+}
+
+// evalIncr runs incrScript via EVALSHA when a SHA is cached, falling back
+// to EVAL (and reloading the SHA) if the server replies NOSCRIPT, e.g.
+// after a SCRIPT FLUSH or a failover to a server that never saw it.
+// incrSHA is shared across the concurrent goroutines handling each
+// request, so reads and writes of it go through shaMu.
+func (s *RedisStore) evalIncr(key string, ttlMs int64) (interface{}, error) {
+	argv := []string{strconv.FormatInt(ttlMs, 10)}
+
+	if sha := s.loadIncrSHA(); sha != "" {
+		reply, err := s.eval.evalSha(sha, []string{key}, argv)
+		if err == nil {
+			return reply, nil
+		}
+
+		if !strings.Contains(err.Error(), "NOSCRIPT") {
+			return nil, err
+		}
+	}
+
+	reply, err := s.eval.eval(incrScript, []string{key}, argv)
+	if err != nil {
+		return nil, err
+	}
+
+	if sha, err := s.eval.scriptLoad(incrScript); err == nil {
+		s.storeIncrSHA(sha)
+	}
+
+	return reply, nil
+}
+
+func (s *RedisStore) loadIncrSHA() string {
+	s.shaMu.Lock()
+	defer s.shaMu.Unlock()
+
+	return s.incrSHA
+}
+
+func (s *RedisStore) storeIncrSHA(sha string) {
+	s.shaMu.Lock()
+	defer s.shaMu.Unlock()
+
+	s.incrSHA = sha
+}
+
+func (s *RedisStore) Reset(key string) error {
+	_, err := s.client.Del(key)
+	return err
+}
+
+func (s *RedisStore) Peek(key string) (int64, time.Duration, error) {
+	raw, err := s.client.Get(key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if raw == nil {
+		return 0, 0, nil
+	}
+
+	count, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ttl, err := s.client.Ttl(key)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, time.Duration(ttl) * time.Second, nil
+}
+
+// TakeSlidingWindow records a hit for key scored by the current time,
+// trims anything older than duration out of the window, and returns the
+// number of hits remaining in it.
+func (s *RedisStore) TakeSlidingWindow(key string, duration time.Duration) (int64, error) {
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10)
+
+	if _, err := s.client.Zadd(key, []byte(member), float64(now.UnixNano())); err != nil {
+		return 0, err
+	}
+
+	cutoff := now.Add(-duration).UnixNano()
+	if _, err := s.client.Zremrangebyscore(key, 0, float64(cutoff)); err != nil {
+		return 0, err
+	}
+
+	count, err := s.client.Zcard(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := s.client.Expire(key, int64(duration.Seconds())+1); err != nil {
+		return 0, err
+	}
+
+	return int64(count), nil
+}
+
+// TakeTokenBucket refills and takes a token from the bucket stored at key
+// via tokenBucketScript, so the refill calculation and the deduction
+// happen as a single atomic redis operation.
+func (s *RedisStore) TakeTokenBucket(key string, rate float64, capacity int64, duration time.Duration) (bool, int64, time.Duration, error) {
+	reply, err := s.eval.eval(tokenBucketScript, []string{key}, []string{
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		strconv.FormatInt(capacity, 10),
+		strconv.FormatInt(time.Now().UnixNano(), 10),
+		strconv.FormatInt(int64(duration.Seconds())+1, 10),
+	})
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 3 {
+		return false, 0, 0, fmt.Errorf("go-limit: unexpected token bucket reply %v", reply)
+	}
+
+	allowed := fmt.Sprint(parts[0]) == "1"
+
+	retrySeconds, err := strconv.ParseFloat(fmt.Sprint(parts[1]), 64)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	remaining, err := strconv.ParseInt(fmt.Sprint(parts[2]), 10, 64)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	return allowed, remaining, time.Duration(retrySeconds * float64(time.Second)), nil
+}
+
+// Keys and Del proxy directly to the underlying redis client, for callers
+// that need raw access to the connection, e.g. to clear out keys between
+// test runs.
+func (s *RedisStore) Keys(pattern string) ([]string, error) {
+	return s.client.Keys(pattern)
+}
+
+func (s *RedisStore) Del(key string) (bool, error) {
+	return s.client.Del(key)
+}
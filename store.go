@@ -0,0 +1,34 @@
+package golimit
+
+import "time"
+
+// A Store persists request counts for rate limited keys. Implementations
+// must be safe for concurrent use.
+type Store interface {
+	// Incr increments the counter for key, creating it with the given
+	// duration if it doesn't already exist (or has expired), and returns
+	// the updated count along with the time remaining until the window
+	// resets.
+	Incr(key string, duration time.Duration) (count int64, ttl time.Duration, err error)
+	// Reset clears any stored count for key.
+	Reset(key string) error
+	// Peek returns the current count and remaining ttl for key without
+	// incrementing it.
+	Peek(key string) (count int64, ttl time.Duration, err error)
+}
+
+// A windowedStore is a Store that can also evaluate the SlidingWindow and
+// TokenBucket algorithms, both of which need atomicity guarantees beyond
+// a plain Incr. RedisStore implements this; MemoryStore does not, so
+// Limits using those algorithms require a RedisStore.
+type windowedStore interface {
+	// TakeSlidingWindow records a hit against key and returns the number
+	// of hits within the trailing duration window.
+	TakeSlidingWindow(key string, duration time.Duration) (count int64, err error)
+	// TakeTokenBucket attempts to take a single token from a bucket of
+	// the given capacity that refills at rate tokens per second (e.g.
+	// Requests/Duration). It returns whether a token was available, the
+	// tokens left in the bucket afterwards, and, if not allowed, how long
+	// until one frees up.
+	TakeTokenBucket(key string, rate float64, capacity int64, duration time.Duration) (allowed bool, remaining int64, retryAfter time.Duration, err error)
+}